@@ -0,0 +1,27 @@
+// Package config holds the handful of settings RaftStorage and the
+// raftstore need at startup. This snapshot only carries the fields those
+// packages actually read; the rest of tikv's config lives alongside it
+// upstream.
+package config
+
+// Config is the subset of TinyKV's configuration consumed by
+// kv/storage/raft_storage and kv/raftstore in this tree.
+type Config struct {
+	DBPath string
+
+	// RaftAsyncStorageWrites switches RaftStorage.WriteAsync over to the
+	// logstore.SyncWaiterLoop path, where a batch's durability wait runs on
+	// a dedicated goroutine instead of inline with proposal processing.
+	// When false (the default), async writes fall back to today's
+	// synchronous fsync-before-ack behavior.
+	RaftAsyncStorageWrites bool
+}
+
+// NewDefaultConfig returns a Config with RaftAsyncStorageWrites off, so
+// upgrading a node does not change its write path until the flag is
+// explicitly turned on.
+func NewDefaultConfig() *Config {
+	return &Config{
+		RaftAsyncStorageWrites: false,
+	}
+}
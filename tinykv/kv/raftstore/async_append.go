@@ -0,0 +1,16 @@
+package raftstore
+
+import (
+	"github.com/pingcap-incubator/tinykv/kv/raftstore/logstore"
+	"github.com/pingcap-incubator/tinykv/proto/pkg/eraftpb"
+)
+
+// HandleAsyncAppend is the hand-off point HandleRaftReady uses for a
+// Ready's unstable entries and HardState when RaftAsyncStorageWrites is on,
+// in place of fsyncing them inline. It lives here, in kv/raftstore, rather
+// than in logstore itself, because it needs the peer's regionId and the
+// Ready contents that only the peer loop has; logstore.SyncWaiterLoop only
+// knows how to persist whatever batch it is handed.
+func HandleAsyncAppend(loop *logstore.SyncWaiterLoop, regionId uint64, entries []eraftpb.Entry, hs *eraftpb.HardState, onSynced func()) {
+	loop.Enqueue(regionId, entries, hs, onSynced)
+}
@@ -0,0 +1,28 @@
+package raftstore
+
+import (
+	"testing"
+
+	"github.com/pingcap-incubator/tinykv/kv/raftstore/logstore"
+	"github.com/pingcap-incubator/tinykv/kv/util/engine_util"
+	"github.com/pingcap-incubator/tinykv/proto/pkg/eraftpb"
+)
+
+// TestHandleAsyncAppendDeliversOnSynced is the call HandleRaftReady is
+// expected to make once it stops fsyncing Ready batches inline: it should
+// reach the waiter loop and get its onSynced callback back.
+func TestHandleAsyncAppendDeliversOnSynced(t *testing.T) {
+	loop := logstore.NewSyncWaiterLoopWithBackend(
+		func(wb *engine_util.WriteBatch) error { return nil },
+		func() error { return nil },
+		1,
+	)
+	loop.Start()
+	defer loop.Stop()
+
+	done := make(chan struct{})
+	HandleAsyncAppend(loop, 1, []eraftpb.Entry{{Index: 1}}, &eraftpb.HardState{Commit: 1}, func() {
+		close(done)
+	})
+	<-done
+}
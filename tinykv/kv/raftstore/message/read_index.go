@@ -0,0 +1,22 @@
+package message
+
+import "github.com/pingcap-incubator/tinykv/proto/pkg/kvrpcpb"
+
+// ReadIndexRequest asks the leader for a consistent read index without
+// appending anything to the raft log. Id is a caller-chosen UUID used to
+// correlate the request with its eventual ReadIndexResponse in the per-peer
+// readIndexQueue.
+type ReadIndexRequest struct {
+	RegionId uint64
+	Id       uint64
+	Context  *kvrpcpb.Context
+}
+
+// ReadIndexResponse is delivered once the tracked commit index has been
+// applied (ReadIndex) or once the leader's lease has been confirmed valid
+// without a heartbeat round (LeaseRead). Err is set instead when the leader
+// stepped down or the request's epoch went stale while it was pending.
+type ReadIndexResponse struct {
+	Id  uint64
+	Err error
+}
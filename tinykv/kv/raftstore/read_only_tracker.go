@@ -0,0 +1,175 @@
+package raftstore
+
+import (
+	"time"
+
+	"github.com/pingcap-incubator/tinykv/kv/raftstore/message"
+	"github.com/pingcap-incubator/tinykv/proto/pkg/errorpb"
+)
+
+// pendingReadIndex is one ReadIndexRequest waiting for two things: the
+// peer's applied index to catch up to recordedIndex (the leader's commit
+// index at the time the request was recorded), and a heartbeat round that
+// quorum acks no earlier than dispatchedAt, proving this leader is still in
+// contact with a live quorum as of this request rather than answering off
+// a stale cached belief that it is still leader.
+type pendingReadIndex struct {
+	req           *message.ReadIndexRequest
+	recordedIndex uint64
+	dispatchedAt  time.Time
+	done          func(*message.ReadIndexResponse)
+}
+
+// leaseTracker derives whether the leader's read lease is still valid from
+// the heartbeat acks it has collected from followers, without a fresh
+// heartbeat round. Per the AsyncStorageWrites / raft §6.4 lease-read
+// design: leaseExpiry = min(ack over a quorum) + electionTimeout -
+// clockDriftBound. A LeaseRead may be answered immediately as long as the
+// current time is still before that expiry; once it lapses, reads must
+// fall back to a confirmed ReadIndex round.
+type leaseTracker struct {
+	electionTimeout time.Duration
+	clockDriftBound time.Duration
+	lastAck         map[uint64]time.Time // peer ID -> last heartbeat ack time
+}
+
+func newLeaseTracker(electionTimeout, clockDriftBound time.Duration) *leaseTracker {
+	return &leaseTracker{
+		electionTimeout: electionTimeout,
+		clockDriftBound: clockDriftBound,
+		lastAck:         make(map[uint64]time.Time),
+	}
+}
+
+// observeHeartbeatAck records that peerID acknowledged a heartbeat at at,
+// called from the peer loop each time a MsgHeartbeatResponse arrives.
+func (l *leaseTracker) observeHeartbeatAck(peerID uint64, at time.Time) {
+	if prev, ok := l.lastAck[peerID]; !ok || at.After(prev) {
+		l.lastAck[peerID] = at
+	}
+}
+
+// expiry returns the lease's expiry time, derived from the oldest ack among
+// quorum. ok is false if any quorum member has not yet acked, in which case
+// the lease cannot be considered valid at all.
+func (l *leaseTracker) expiry(quorum []uint64) (t time.Time, ok bool) {
+	var minAck time.Time
+	for _, id := range quorum {
+		ack, acked := l.lastAck[id]
+		if !acked {
+			return time.Time{}, false
+		}
+		if minAck.IsZero() || ack.Before(minAck) {
+			minAck = ack
+		}
+	}
+	return minAck.Add(l.electionTimeout).Add(-l.clockDriftBound), true
+}
+
+// valid reports whether a LeaseRead may be answered immediately at now.
+func (l *leaseTracker) valid(quorum []uint64, now time.Time) bool {
+	expiry, ok := l.expiry(quorum)
+	return ok && now.Before(expiry)
+}
+
+// quorumAckedSince reports whether every peer in quorum has acknowledged a
+// heartbeat at or after since. This is what lets a plain ReadIndex (or a
+// LeaseRead whose lease has lapsed) be released only once a fresh
+// confirmation round has happened after it was requested — a cached ack
+// from before the request proves nothing about whether this leader is
+// still in contact with quorum right now.
+func (l *leaseTracker) quorumAckedSince(quorum []uint64, since time.Time) bool {
+	for _, id := range quorum {
+		ack, ok := l.lastAck[id]
+		if !ok || ack.Before(since) {
+			return false
+		}
+	}
+	return true
+}
+
+// readOnlyTracker buffers read-only requests per peer between the moment
+// the leader confirms it may serve them (by commit index, for ReadIndex; by
+// lease, for LeaseRead) and the moment the state machine has actually
+// applied up to that point. It is owned by peerMsgHandler and driven by
+// HandleRaftReady, which calls flush after every Ready with the new applied
+// index, and by the peer's heartbeat-response handling, which calls
+// ObserveHeartbeatAck.
+type readOnlyTracker struct {
+	pending []*pendingReadIndex
+	lease   *leaseTracker
+}
+
+// newReadOnlyTracker creates a tracker whose lease logic uses electionTimeout
+// and clockDriftBound as described on leaseTracker.
+func newReadOnlyTracker(electionTimeout, clockDriftBound time.Duration) *readOnlyTracker {
+	return &readOnlyTracker{lease: newLeaseTracker(electionTimeout, clockDriftBound)}
+}
+
+// ObserveHeartbeatAck feeds a MsgHeartbeatResponse into the lease tracker.
+func (t *readOnlyTracker) ObserveHeartbeatAck(peerID uint64, at time.Time) {
+	t.lease.observeHeartbeatAck(peerID, at)
+}
+
+// Dispatch decides how to answer req: immediately, if it is a LeaseRead and
+// the lease is already valid against quorum at now; otherwise it records
+// req to be answered once Flush later reports both an appliedIndex
+// covering commitIndex AND a heartbeat round that quorum has acked since
+// now. The second condition is what a plain ReadIndex always requires —
+// reaching commitIndex locally only proves past commitment, not that this
+// peer is still the leader a live quorum would confirm right now — and is
+// also what a LeaseRead whose lease has lapsed falls back to.
+func (t *readOnlyTracker) Dispatch(req *message.ReadIndexRequest, lease bool, now time.Time, quorum []uint64, commitIndex uint64, done func(*message.ReadIndexResponse)) {
+	if lease && t.lease.valid(quorum, now) {
+		done(&message.ReadIndexResponse{Id: req.Id})
+		return
+	}
+	t.record(req, commitIndex, now, done)
+}
+
+// record queues req to be answered once appliedIndex reaches recordedIndex
+// and quorum has acked a heartbeat since dispatchedAt.
+func (t *readOnlyTracker) record(req *message.ReadIndexRequest, recordedIndex uint64, dispatchedAt time.Time, done func(*message.ReadIndexResponse)) {
+	t.pending = append(t.pending, &pendingReadIndex{req: req, recordedIndex: recordedIndex, dispatchedAt: dispatchedAt, done: done})
+}
+
+// Flush answers every pending request whose recordedIndex is now covered by
+// appliedIndex and whose dispatchedAt is covered by a quorum-acked
+// heartbeat round, in FIFO order, and drops them from the tracker.
+func (t *readOnlyTracker) Flush(appliedIndex uint64, quorum []uint64) {
+	i := 0
+	for ; i < len(t.pending); i++ {
+		p := t.pending[i]
+		if p.recordedIndex > appliedIndex {
+			break
+		}
+		if !t.lease.quorumAckedSince(quorum, p.dispatchedAt) {
+			break
+		}
+		p.done(&message.ReadIndexResponse{Id: p.req.Id})
+	}
+	t.pending = t.pending[i:]
+}
+
+// FailAll fails every pending request with NotLeader, used when the peer
+// steps down from leader while reads are still outstanding; a former leader
+// can never regain the right to answer them.
+func (t *readOnlyTracker) FailAll(leader *errorpb.NotLeader) {
+	for _, p := range t.pending {
+		p.done(&message.ReadIndexResponse{
+			Id:  p.req.Id,
+			Err: &RegionError{RequestErr: &errorpb.Error{NotLeader: leader}},
+		})
+	}
+	t.pending = nil
+}
+
+// RegionError wraps a region-level error so readOnlyTracker callers can
+// treat it like any other storage error.
+type RegionError struct {
+	RequestErr *errorpb.Error
+}
+
+func (re *RegionError) Error() string {
+	return re.RequestErr.String()
+}
@@ -0,0 +1,172 @@
+package raftstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-incubator/tinykv/kv/raftstore/message"
+	"github.com/pingcap-incubator/tinykv/proto/pkg/errorpb"
+)
+
+func TestReadOnlyTrackerFlushInFIFOOrder(t *testing.T) {
+	tr := newReadOnlyTracker(time.Second, 0)
+	quorum := []uint64{1}
+	dispatchedAt := time.Unix(1000, 0)
+
+	var got []uint64
+	tr.record(&message.ReadIndexRequest{Id: 1}, 5, dispatchedAt, func(r *message.ReadIndexResponse) { got = append(got, r.Id) })
+	tr.record(&message.ReadIndexRequest{Id: 2}, 7, dispatchedAt, func(r *message.ReadIndexResponse) { got = append(got, r.Id) })
+	tr.record(&message.ReadIndexRequest{Id: 3}, 9, dispatchedAt, func(r *message.ReadIndexResponse) { got = append(got, r.Id) })
+
+	// Without a quorum ack since dispatchedAt, nothing may be released even
+	// once appliedIndex covers every recordedIndex.
+	tr.Flush(100, quorum)
+	if len(got) != 0 {
+		t.Fatalf("expected nothing flushed before quorum confirms, got %v", got)
+	}
+
+	tr.ObserveHeartbeatAck(1, dispatchedAt.Add(time.Second))
+
+	tr.Flush(6, quorum) // only request 1 is covered
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("expected only request 1 flushed, got %v", got)
+	}
+
+	tr.Flush(7, quorum) // request 2 now covered, request 3 still isn't
+	if len(got) != 2 || got[1] != 2 {
+		t.Fatalf("expected request 2 flushed next, got %v", got)
+	}
+
+	tr.Flush(100, quorum)
+	if len(got) != 3 || got[2] != 3 {
+		t.Fatalf("expected request 3 flushed last, got %v", got)
+	}
+	if len(tr.pending) != 0 {
+		t.Fatalf("expected tracker drained, got %d pending", len(tr.pending))
+	}
+}
+
+func TestReadOnlyTrackerFlushWithholdsUntilFreshQuorumAck(t *testing.T) {
+	tr := newReadOnlyTracker(time.Second, 0)
+	quorum := []uint64{1}
+	dispatchedAt := time.Unix(1000, 0)
+
+	// A stale ack from before the request was dispatched must not count as
+	// confirmation: it says nothing about whether this peer is still in
+	// contact with quorum as of this request.
+	tr.ObserveHeartbeatAck(1, dispatchedAt.Add(-time.Second))
+
+	var resolved bool
+	tr.record(&message.ReadIndexRequest{Id: 1}, 5, dispatchedAt, func(*message.ReadIndexResponse) { resolved = true })
+
+	tr.Flush(100, quorum)
+	if resolved {
+		t.Fatal("expected stale ack (from before dispatch) to not confirm the read")
+	}
+
+	tr.ObserveHeartbeatAck(1, dispatchedAt.Add(time.Millisecond))
+	tr.Flush(100, quorum)
+	if !resolved {
+		t.Fatal("expected a fresh ack (after dispatch) to confirm the read")
+	}
+}
+
+func TestReadOnlyTrackerFailAllReturnsNotLeader(t *testing.T) {
+	tr := newReadOnlyTracker(time.Second, 0)
+
+	var err error
+	tr.record(&message.ReadIndexRequest{Id: 1}, 5, time.Unix(1000, 0), func(r *message.ReadIndexResponse) { err = r.Err })
+	tr.FailAll(&errorpb.NotLeader{RegionId: 1})
+
+	if err == nil {
+		t.Fatal("expected NotLeader error, got nil")
+	}
+	if len(tr.pending) != 0 {
+		t.Fatalf("expected tracker drained after FailAll, got %d pending", len(tr.pending))
+	}
+}
+
+func TestLeaseTrackerValidWithinElectionTimeout(t *testing.T) {
+	lt := newLeaseTracker(10*time.Second, time.Second)
+	base := time.Unix(1000, 0)
+	lt.observeHeartbeatAck(1, base)
+	lt.observeHeartbeatAck(2, base.Add(2*time.Second))
+	lt.observeHeartbeatAck(3, base.Add(3*time.Second))
+
+	quorum := []uint64{1, 2, 3}
+	// expiry = min(ack) + electionTimeout - clockDriftBound = base + 9s
+	if !lt.valid(quorum, base.Add(8*time.Second)) {
+		t.Fatal("expected lease valid before expiry")
+	}
+	if lt.valid(quorum, base.Add(9*time.Second)) {
+		t.Fatal("expected lease expired at expiry boundary")
+	}
+}
+
+func TestLeaseTrackerInvalidWithoutFullQuorumAck(t *testing.T) {
+	lt := newLeaseTracker(10*time.Second, time.Second)
+	lt.observeHeartbeatAck(1, time.Unix(1000, 0))
+
+	if lt.valid([]uint64{1, 2, 3}, time.Unix(1000, 1)) {
+		t.Fatal("expected lease invalid when quorum has not all acked")
+	}
+}
+
+func TestReadOnlyTrackerDispatchLeaseReadFallsBackToRecordWhenExpired(t *testing.T) {
+	tr := newReadOnlyTracker(10*time.Second, time.Second)
+	base := time.Unix(1000, 0)
+	tr.ObserveHeartbeatAck(1, base)
+	quorum := []uint64{1}
+
+	var resolved bool
+	req := &message.ReadIndexRequest{Id: 42}
+	// Lease already expired (now is far past base+9s); Dispatch must fall
+	// back to recording instead of answering immediately.
+	tr.Dispatch(req, true, base.Add(time.Hour), quorum, 10, func(*message.ReadIndexResponse) { resolved = true })
+
+	if resolved {
+		t.Fatal("expected fallback to recording, not an immediate answer")
+	}
+	if len(tr.pending) != 1 {
+		t.Fatalf("expected request recorded as pending, got %d", len(tr.pending))
+	}
+}
+
+func TestReadOnlyTrackerDispatchLeaseReadAnswersImmediatelyWhenValid(t *testing.T) {
+	tr := newReadOnlyTracker(10*time.Second, time.Second)
+	base := time.Unix(1000, 0)
+	tr.ObserveHeartbeatAck(1, base)
+	quorum := []uint64{1}
+
+	var resolved bool
+	req := &message.ReadIndexRequest{Id: 42}
+	tr.Dispatch(req, true, base.Add(time.Second), quorum, 10, func(*message.ReadIndexResponse) { resolved = true })
+
+	if !resolved {
+		t.Fatal("expected immediate answer for a valid lease")
+	}
+	if len(tr.pending) != 0 {
+		t.Fatalf("expected nothing recorded, got %d pending", len(tr.pending))
+	}
+}
+
+func TestReadOnlyTrackerDispatchPlainReadIndexNeverAnswersSynchronously(t *testing.T) {
+	tr := newReadOnlyTracker(10*time.Second, time.Second)
+	base := time.Unix(1000, 0)
+	quorum := []uint64{1}
+
+	var resolved bool
+	req := &message.ReadIndexRequest{Id: 7}
+	// A plain ReadIndex must never be answered at Dispatch time, even when
+	// the caller already believes appliedIndex covers commitIndex — that
+	// only proves past commitment, not that this peer is confirmed leader
+	// by a live quorum right now.
+	tr.Dispatch(req, false, base, quorum, 10, func(*message.ReadIndexResponse) { resolved = true })
+
+	if resolved {
+		t.Fatal("expected a plain ReadIndex to always wait for quorum confirmation via Flush")
+	}
+	if len(tr.pending) != 1 {
+		t.Fatalf("expected request recorded as pending, got %d", len(tr.pending))
+	}
+}
@@ -0,0 +1,142 @@
+package snap
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pingcap/errors"
+)
+
+// receiverPoolSize is the number of dedicated chunkedReceiver goroutines
+// that service incoming Snapshot streams. Splitting the single snapWorker
+// of the old design into a pool means one large region's transfer can no
+// longer head-of-line-block a small one behind it.
+const receiverPoolSize = 4
+
+// Chunk is one fragment of a streamed snapshot transfer, CRC32C-protected
+// so a corrupted fragment is caught before it is written to disk. It is a
+// plain struct rather than a tinykvpb message because this checkout's
+// proto/pkg/tinykvpb does not define a wire type for it yet; the gRPC
+// stream glue (snapRunner) is expected to convert to/from the generated
+// type once the .proto gains one, the same way it already converts
+// raft_cmdpb messages today.
+type Chunk struct {
+	Data   []byte
+	Crc32C uint32
+}
+
+// NewChunk builds a Chunk with its CRC32C computed from data.
+func NewChunk(data []byte) *Chunk {
+	return &Chunk{Data: data, Crc32C: crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))}
+}
+
+// chunkedReceiver streams one region's snapshot chunks straight into a
+// temp file as they arrive, instead of buffering the whole snapshot in
+// memory before handing it to Badger. Progress is checkpointed to a
+// transferProgress file after every progressCheckpointBytes so a dropped
+// connection can resume from the last committed offset when the sender
+// reconnects.
+//
+// This lands the streaming/resumable/checksummed transport the request
+// asked for. It stops short of ingesting straight into a CF-partitioned
+// Badger sst via engine_util, since that ingest API is not present in this
+// checkout (engine_util has no source files here beyond what earlier
+// commits in this series added); finish() below hands off a single
+// assembled temp file instead, which is what the real ingest step would
+// consume once it exists.
+type chunkedReceiver struct {
+	progress *transferProgress
+	tmp      *os.File
+}
+
+// newChunkedReceiver prepares to receive the snapshot identified by
+// regionID/term/index into dir, resuming from a prior transferProgress if
+// one is found on disk there.
+func newChunkedReceiver(dir string, regionID, term, index uint64) (*chunkedReceiver, error) {
+	progress := newTransferProgress(dir, regionID, term, index)
+	resumeFrom, err := progress.load()
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.OpenFile(tmpFilePath(dir, regionID, term, index), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := tmp.Seek(int64(resumeFrom), io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &chunkedReceiver{progress: progress, tmp: tmp}, nil
+}
+
+func tmpFilePath(dir string, regionID, term, index uint64) string {
+	return filepath.Join(dir, progressFileName(regionID, term, index)+".tmp-data")
+}
+
+// recv consumes one chunk off the stream, verifies its CRC32C, writes it to
+// the temp file, and advances the checkpoint. It returns io.EOF once the
+// sender signals the snapshot is complete.
+func (r *chunkedReceiver) recv(chunk *Chunk) error {
+	if crc32.Checksum(chunk.Data, crc32.MakeTable(crc32.Castagnoli)) != chunk.Crc32C {
+		return errors.New("snapshot chunk failed CRC32C check")
+	}
+	if _, err := r.tmp.Write(chunk.Data); err != nil {
+		return errors.WithStack(err)
+	}
+	return r.progress.advance(uint64(len(chunk.Data)))
+}
+
+// finish closes the temp file and removes the progress checkpoint, since
+// the transfer is now durable and complete; the caller is responsible for
+// handing the assembled file off to ingestion.
+func (r *chunkedReceiver) finish() error {
+	if err := r.tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return r.progress.done()
+}
+
+// Offset reports how many bytes of this transfer have been committed so
+// far, for SnapshotStatus.
+func (r *chunkedReceiver) Offset() uint64 {
+	return r.progress.offset
+}
+
+// recvSnapPool is a fixed-size pool of chunkedReceiver workers, replacing
+// the single snapWorker that used to serialize every incoming transfer.
+type recvSnapPool struct {
+	dir     string
+	workers chan struct{} // counting semaphore sized receiverPoolSize
+}
+
+// newRecvSnapPool creates a pool that receives into mgr's snapshot
+// directory.
+func newRecvSnapPool(mgr *SnapManager) *recvSnapPool {
+	return &recvSnapPool{dir: mgr.dir, workers: make(chan struct{}, receiverPoolSize)}
+}
+
+// handle runs a chunked receive to completion, blocking only until a pool
+// slot is free rather than until the whole snapshot is materialized.
+func (p *recvSnapPool) handle(regionID, term, index uint64, stream func() (*Chunk, error)) error {
+	p.workers <- struct{}{}
+	defer func() { <-p.workers }()
+
+	r, err := newChunkedReceiver(p.dir, regionID, term, index)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := stream()
+		if err == io.EOF {
+			return r.finish()
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.recv(chunk); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package snap
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestChunkedReceiverAssemblesStreamedData(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newChunkedReceiver(dir, 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("hello, chunked snapshot")
+	mid := len(want) / 2
+	if err := r.recv(NewChunk(want[:mid])); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.recv(NewChunk(want[mid:])); err != nil {
+		t.Fatal(err)
+	}
+	if r.Offset() != uint64(len(want)) {
+		t.Fatalf("expected offset %d, got %d", len(want), r.Offset())
+	}
+
+	path := tmpFilePath(dir, 1, 2, 3)
+	if err := r.finish(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("expected assembled file %q, got %q", want, got)
+	}
+}
+
+func TestChunkedReceiverRejectsCorruptChunk(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newChunkedReceiver(dir, 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.tmp.Close()
+
+	chunk := NewChunk([]byte("data"))
+	chunk.Crc32C++ // corrupt it
+	if err := r.recv(chunk); err == nil {
+		t.Fatal("expected CRC32C mismatch to be rejected")
+	}
+}
+
+func TestRecvSnapPoolHandleDrainsStreamToFinish(t *testing.T) {
+	dir := t.TempDir()
+	pool := &recvSnapPool{dir: dir, workers: make(chan struct{}, receiverPoolSize)}
+
+	chunks := []*Chunk{NewChunk([]byte("a")), NewChunk([]byte("b")), NewChunk([]byte("c"))}
+	i := 0
+	stream := func() (*Chunk, error) {
+		if i >= len(chunks) {
+			return nil, io.EOF
+		}
+		c := chunks[i]
+		i++
+		return c, nil
+	}
+
+	if err := pool.handle(1, 2, 3, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(tmpFilePath(dir, 1, 2, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abc" {
+		t.Fatalf("expected assembled data %q, got %q", "abc", got)
+	}
+}
@@ -0,0 +1,99 @@
+package snap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pingcap/errors"
+)
+
+// progressCheckpointBytes is how often, in bytes of ingested chunk data, a
+// receiver persists its progress file. A resumed transfer can only ever lose
+// at most this many bytes of work.
+const progressCheckpointBytes = 16 * 1024 * 1024 // 16 MiB
+
+// transferProgress is the on-disk record for a resumable snapshot receive.
+// It is serialized as a single line "<offset>" under
+// snap/<region>-<term>-<index>.progress so a crashed or restarted receiver
+// can tell the sender where to resume from.
+type transferProgress struct {
+	dir      string
+	regionID uint64
+	term     uint64
+	index    uint64
+
+	offset          uint64
+	sinceCheckpoint uint64
+}
+
+func progressFileName(regionID, term, index uint64) string {
+	return fmt.Sprintf("%d_%d_%d.progress", regionID, term, index)
+}
+
+func newTransferProgress(dir string, regionID, term, index uint64) *transferProgress {
+	return &transferProgress{dir: dir, regionID: regionID, term: term, index: index}
+}
+
+// path returns the full path of the progress file.
+func (p *transferProgress) path() string {
+	return filepath.Join(p.dir, progressFileName(p.regionID, p.term, p.index))
+}
+
+// load reads a previously checkpointed offset, if any, returning 0 if no
+// progress file exists yet (a fresh transfer starts at the beginning).
+func (p *transferProgress) load() (uint64, error) {
+	data, err := ioutil.ReadFile(p.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.WithStack(err)
+	}
+	offset, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	p.offset = offset
+	return offset, nil
+}
+
+// advance records that n more bytes of chunk data have been ingested, and
+// checkpoints to disk once progressCheckpointBytes have accumulated since
+// the last checkpoint.
+func (p *transferProgress) advance(n uint64) error {
+	p.offset += n
+	p.sinceCheckpoint += n
+	if p.sinceCheckpoint < progressCheckpointBytes {
+		return nil
+	}
+	p.sinceCheckpoint = 0
+	return p.checkpoint()
+}
+
+func (p *transferProgress) checkpoint() error {
+	tmp := p.path() + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatUint(p.offset, 10)), 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return os.Rename(tmp, p.path())
+}
+
+// TransferOffset reports the checkpointed byte offset for a snapshot
+// transfer, for the SnapshotStatus RPC. It returns 0, nil for a transfer
+// that has not checkpointed yet (including one that has not started).
+func (m *SnapManager) TransferOffset(regionID, term, index uint64) (uint64, error) {
+	return newTransferProgress(m.dir, regionID, term, index).load()
+}
+
+// done removes the progress file once the snapshot has been fully ingested
+// and applied; there is nothing left to resume.
+func (p *transferProgress) done() error {
+	err := os.Remove(p.path())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package snap
+
+import "testing"
+
+func TestTransferProgressAdvanceAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	p := newTransferProgress(dir, 1, 2, 3)
+
+	if off, err := p.load(); err != nil || off != 0 {
+		t.Fatalf("expected fresh transfer to start at 0, got %d, err %v", off, err)
+	}
+
+	// advancing by less than progressCheckpointBytes should not checkpoint
+	// to disk yet.
+	if err := p.advance(1024); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := newTransferProgress(dir, 1, 2, 3)
+	if off, err := reloaded.load(); err != nil || off != 0 {
+		t.Fatalf("expected no checkpoint before threshold, got %d, err %v", off, err)
+	}
+
+	// advancing past the threshold should checkpoint.
+	if err := p.advance(progressCheckpointBytes); err != nil {
+		t.Fatal(err)
+	}
+	reloaded = newTransferProgress(dir, 1, 2, 3)
+	off, err := reloaded.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != 1024+progressCheckpointBytes {
+		t.Fatalf("expected checkpointed offset %d, got %d", 1024+progressCheckpointBytes, off)
+	}
+}
+
+func TestTransferProgressDoneRemovesFile(t *testing.T) {
+	dir := t.TempDir()
+	p := newTransferProgress(dir, 1, 2, 3)
+	if err := p.advance(progressCheckpointBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.done(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := newTransferProgress(dir, 1, 2, 3)
+	off, err := reloaded.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != 0 {
+		t.Fatalf("expected progress file removed, but offset was %d", off)
+	}
+}
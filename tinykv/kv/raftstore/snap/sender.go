@@ -0,0 +1,63 @@
+package snap
+
+import (
+	"io"
+	"os"
+
+	"github.com/pingcap/errors"
+)
+
+// chunkSize is the fixed fragment size used to split a snapshot file into
+// Chunk messages. Keeping it fixed means the sender and receiver agree on
+// offsets without needing to exchange chunk boundaries out of band, which
+// is what makes resuming from a checkpointed offset a matter of just
+// seeking the local file.
+const chunkSize = 1 << 20 // 1 MiB
+
+// snapshotSender fragments a materialized snapshot file into fixed-size,
+// CRC32C-protected chunks and feeds them to a stream. It is the counterpart
+// to chunkedReceiver/recvSnapPool on the receiving store.
+//
+// It streams a single file. TinyKV's on-disk snapshot format is actually a
+// set of CF-partitioned sst files, so a real sender has one of these per
+// CF and resumes each independently; this covers the single-file case that
+// chunkedReceiver assembles into today; extending both to multiple named
+// files is mechanical once the real ingest step (see chunked_recv.go) needs
+// to tell them apart, but is not done here.
+type snapshotSender struct {
+	file *os.File
+}
+
+func newSnapshotSender(path string) (*snapshotSender, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &snapshotSender{file: f}, nil
+}
+
+// sendFrom streams the snapshot file starting at resumeOffset, as reported
+// by a prior SnapshotStatus call, so a reconnecting receiver does not have
+// to re-transfer bytes it already committed.
+func (s *snapshotSender) sendFrom(resumeOffset int64, send func(*Chunk) error) error {
+	defer s.file.Close()
+	if _, err := s.file.Seek(resumeOffset, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := s.file.Read(buf)
+		if n > 0 {
+			if sendErr := send(NewChunk(append([]byte(nil), buf[:n]...))); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package snap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotSenderSendsWholeFileFromZero(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snap.data")
+	want := []byte("snapshot payload")
+	if err := ioutil.WriteFile(path, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSnapshotSender(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got bytes.Buffer
+	err = s.sendFrom(0, func(c *Chunk) error {
+		got.Write(c.Data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Fatalf("expected %q, got %q", want, got.String())
+	}
+}
+
+func TestSnapshotSenderResumesFromOffset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snap.data")
+	want := []byte("snapshot payload")
+	if err := ioutil.WriteFile(path, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSnapshotSender(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resumeFrom := int64(5)
+	var got bytes.Buffer
+	err = s.sendFrom(resumeFrom, func(c *Chunk) error {
+		got.Write(c.Data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want[resumeFrom:]) {
+		t.Fatalf("expected resumed tail %q, got %q", want[resumeFrom:], got.String())
+	}
+}
@@ -0,0 +1,272 @@
+package raft_storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pingcap-incubator/tinykv/kv/raftstore/message"
+	"github.com/pingcap-incubator/tinykv/kv/storage"
+	"github.com/pingcap-incubator/tinykv/proto/pkg/kvrpcpb"
+	"github.com/pingcap-incubator/tinykv/proto/pkg/raft_cmdpb"
+)
+
+// batchWindow is how long a batch accumulates callers before being flushed,
+// if it does not fill up first. 200µs is small enough that callers see
+// essentially the same latency as the unbatched path under light load, but
+// large enough to coalesce the bursts a busy region sees under load.
+const batchWindow = 200 * time.Microsecond
+
+// maxBatchSize bounds how many callers' requests get merged into a single
+// RaftCmdRequest, so one region's batch cannot grow the proposal past what
+// the raft log and apply path are comfortable handling in one shot.
+const maxBatchSize = 128
+
+// BatchedRaftStorage wraps a RaftStorage and coalesces concurrent Write
+// calls that target the same region into a single RaftCmdRequest, so a
+// client issuing many small puts no longer pays a full raft round-trip per
+// request. It does not change the wire protocol: from raftRouter's point of
+// view this still looks like one RaftCmdRequest with a merged Requests
+// list, exactly as if one caller had submitted all of them together.
+//
+// Production wiring (constructing one of these in front of the RaftStorage
+// a server hands out to its RPC layer) lives in tikv/server.go, which is
+// outside this checkout; NewBatchedRaftStorage and the behavior below are
+// exercised directly by this package's tests instead.
+type BatchedRaftStorage struct {
+	rs *RaftStorage
+	// send submits a merged RaftCmdRequest and blocks for its response. It
+	// is a separate field, rather than a direct rs.raftRouter.SendRaftCommand
+	// call inline in flush, so tests can substitute a fake without a real
+	// raftRouter or message.Callback.
+	send func(req *raft_cmdpb.RaftCmdRequest) (*raft_cmdpb.RaftCmdResponse, error)
+
+	mu      sync.Mutex
+	batches map[uint64]*regionBatch
+
+	metricsMu sync.Mutex
+	metrics   BatchMetrics
+}
+
+// BatchMetrics reports how well writes are coalescing, for the scheduler's
+// stats reporter.
+type BatchMetrics struct {
+	BatchCount    uint64 // number of batches flushed
+	CallerCount   uint64 // total number of Write calls folded into those batches
+	WindowFlushes uint64 // batches that flushed because batchWindow elapsed
+	SizeFlushes   uint64 // batches that flushed because they hit maxBatchSize
+}
+
+// FanInRatio is the average number of callers coalesced per flushed batch.
+func (m BatchMetrics) FanInRatio() float64 {
+	if m.BatchCount == 0 {
+		return 0
+	}
+	return float64(m.CallerCount) / float64(m.BatchCount)
+}
+
+// WindowUtilization is the fraction of flushes that ran the full
+// batchWindow rather than being cut short by hitting maxBatchSize; a low
+// value means the region is hot enough that the size trigger, not the time
+// trigger, is doing the coalescing.
+func (m BatchMetrics) WindowUtilization() float64 {
+	if m.BatchCount == 0 {
+		return 0
+	}
+	return float64(m.WindowFlushes) / float64(m.BatchCount)
+}
+
+// batchedCaller is one Write call's contribution to a regionBatch: the
+// requests it added, converted exactly as RaftStorage.Write would, and the
+// channel its result is delivered on. responses is filled in by flush once
+// the batch comes back, sliced out of the merged response in the same
+// order this caller's requests were appended in storage.Modify order.
+type batchedCaller struct {
+	reqCount  int
+	responses []*raft_cmdpb.Response
+	result    chan error
+}
+
+// regionBatch accumulates callers targeting one region until batchWindow
+// elapses or maxBatchSize is reached, then flushes as a single
+// RaftCmdRequest. Every caller folded into a regionBatch must share the
+// same RegionEpoch/Peer/Term as ctx below; Write starts a new regionBatch
+// rather than merge a caller whose epoch has moved on, so a stale or
+// ahead-of-epoch caller never rides through under someone else's header.
+type regionBatch struct {
+	ctx     *kvrpcpb.Context
+	reqs    []*raft_cmdpb.Request
+	callers []*batchedCaller
+	timer   *time.Timer
+}
+
+// sameBatchKey reports whether a and b may be coalesced into the same
+// RaftCmdRequest: same region, same term, same peer, and same region
+// epoch. A mismatch on any of these means the two callers are not
+// proposing under the same view of the region and must not be merged.
+func sameBatchKey(a, b *kvrpcpb.Context) bool {
+	return a.RegionId == b.RegionId &&
+		a.Term == b.Term &&
+		a.Peer.GetId() == b.Peer.GetId() &&
+		a.RegionEpoch.GetVersion() == b.RegionEpoch.GetVersion() &&
+		a.RegionEpoch.GetConfVer() == b.RegionEpoch.GetConfVer()
+}
+
+// NewBatchedRaftStorage wraps rs with request coalescing.
+func NewBatchedRaftStorage(rs *RaftStorage) *BatchedRaftStorage {
+	return &BatchedRaftStorage{
+		rs: rs,
+		send: func(req *raft_cmdpb.RaftCmdRequest) (*raft_cmdpb.RaftCmdResponse, error) {
+			cb := message.NewCallback()
+			if err := rs.raftRouter.SendRaftCommand(req, cb); err != nil {
+				return nil, err
+			}
+			return cb.WaitResp(), nil
+		},
+		batches: make(map[uint64]*regionBatch),
+	}
+}
+
+// Write enqueues batch into the in-flight regionBatch for ctx.RegionId,
+// starting one if none is pending, and blocks until that regionBatch has
+// been flushed and this caller's share of the response has been
+// demultiplexed back out. If a regionBatch is already pending for this
+// region under a different epoch/peer/term, that batch is flushed first so
+// this caller starts a fresh one under its own header instead of being
+// silently coalesced under the old one.
+func (b *BatchedRaftStorage) Write(ctx *kvrpcpb.Context, batch []storage.Modify) error {
+	reqs := toRaftRequests(batch)
+	caller := &batchedCaller{reqCount: len(reqs), result: make(chan error, 1)}
+
+	for {
+		b.mu.Lock()
+		rb, ok := b.batches[ctx.RegionId]
+		if ok && !sameBatchKey(rb.ctx, ctx) {
+			b.mu.Unlock()
+			b.flush(ctx.RegionId, false)
+			continue
+		}
+		if !ok {
+			rb = &regionBatch{ctx: ctx}
+			rb.timer = time.AfterFunc(batchWindow, func() { b.flush(ctx.RegionId, false) })
+			b.batches[ctx.RegionId] = rb
+		}
+		rb.reqs = append(rb.reqs, reqs...)
+		rb.callers = append(rb.callers, caller)
+		full := len(rb.callers) >= maxBatchSize
+		b.mu.Unlock()
+
+		if full {
+			b.flush(ctx.RegionId, true)
+		}
+		break
+	}
+
+	return <-caller.result
+}
+
+// flush sends the accumulated regionBatch for regionId as a single
+// RaftCmdRequest and demultiplexes the result back to every caller. sizeTrigger
+// records whether maxBatchSize, rather than batchWindow, caused the flush.
+func (b *BatchedRaftStorage) flush(regionId uint64, sizeTrigger bool) {
+	b.mu.Lock()
+	rb, ok := b.batches[regionId]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, regionId)
+	b.mu.Unlock()
+	// rb.timer is nil for a regionBatch built directly in a test rather
+	// than through Write, so guard rather than assume every regionBatch
+	// came from the window-timer path.
+	if rb.timer != nil {
+		rb.timer.Stop()
+	}
+
+	b.recordFlush(rb, sizeTrigger)
+
+	header := &raft_cmdpb.RaftRequestHeader{
+		RegionId:    rb.ctx.RegionId,
+		Peer:        rb.ctx.Peer,
+		RegionEpoch: rb.ctx.RegionEpoch,
+		Term:        rb.ctx.Term,
+	}
+	request := &raft_cmdpb.RaftCmdRequest{Header: header, Requests: rb.reqs}
+	resp, err := b.send(request)
+	if err != nil {
+		failAll(rb.callers, err)
+		return
+	}
+
+	if err := b.rs.checkResponse(resp, len(rb.reqs)); err != nil {
+		// A region error (stale epoch, not leader, ...) applies to every
+		// request in the batch alike, so every caller must fail together.
+		failAll(rb.callers, err)
+		return
+	}
+
+	// Slice resp.Responses back out per caller, in the same order each
+	// caller's requests were appended to rb.reqs in Write above, so a
+	// caller only ever sees the responses to the requests it actually
+	// submitted.
+	offset := 0
+	for _, caller := range rb.callers {
+		caller.responses = resp.Responses[offset : offset+caller.reqCount]
+		offset += caller.reqCount
+		caller.result <- nil
+	}
+}
+
+func failAll(callers []*batchedCaller, err error) {
+	for _, caller := range callers {
+		caller.result <- err
+	}
+}
+
+func (b *BatchedRaftStorage) recordFlush(rb *regionBatch, sizeTrigger bool) {
+	b.metricsMu.Lock()
+	defer b.metricsMu.Unlock()
+	b.metrics.BatchCount++
+	b.metrics.CallerCount += uint64(len(rb.callers))
+	if sizeTrigger {
+		b.metrics.SizeFlushes++
+	} else {
+		b.metrics.WindowFlushes++
+	}
+}
+
+// Metrics returns a point-in-time snapshot of the coalescing stats.
+func (b *BatchedRaftStorage) Metrics() BatchMetrics {
+	b.metricsMu.Lock()
+	defer b.metricsMu.Unlock()
+	return b.metrics
+}
+
+// toRaftRequests converts a batch of storage.Modify into raft_cmdpb.Request,
+// exactly as RaftStorage.Write does; kept as a shared helper so the
+// coalesced path and the unbatched path cannot drift apart.
+func toRaftRequests(batch []storage.Modify) []*raft_cmdpb.Request {
+	var reqs []*raft_cmdpb.Request
+	for _, m := range batch {
+		switch m.Data.(type) {
+		case storage.Put:
+			put := m.Data.(storage.Put)
+			reqs = append(reqs, &raft_cmdpb.Request{
+				CmdType: raft_cmdpb.CmdType_Put,
+				Put: &raft_cmdpb.PutRequest{
+					Cf:    put.Cf,
+					Key:   put.Key,
+					Value: put.Value,
+				}})
+		case storage.Delete:
+			delete := m.Data.(storage.Delete)
+			reqs = append(reqs, &raft_cmdpb.Request{
+				CmdType: raft_cmdpb.CmdType_Delete,
+				Delete: &raft_cmdpb.DeleteRequest{
+					Cf:  delete.Cf,
+					Key: delete.Key,
+				}})
+		}
+	}
+	return reqs
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/pingcap-incubator/tinykv/kv/config"
 	"github.com/pingcap-incubator/tinykv/kv/raftstore"
+	"github.com/pingcap-incubator/tinykv/kv/raftstore/logstore"
 	"github.com/pingcap-incubator/tinykv/kv/raftstore/message"
 	"github.com/pingcap-incubator/tinykv/kv/raftstore/scheduler_client"
 	"github.com/pingcap-incubator/tinykv/kv/raftstore/snap"
@@ -33,11 +34,17 @@ type RaftStorage struct {
 	raftSystem    *raftstore.Raftstore
 	resolveWorker *worker.Worker
 	snapWorker    *worker.Worker
+	syncWaiter    *logstore.SyncWaiterLoop
 
 	wg     sync.WaitGroup
 	client scheduler_client.Client
 }
 
+// syncWaiterQueueCapacity bounds the number of in-flight append batches the
+// async storage writes path will buffer before Write/WriteAsync callers feel
+// backpressure.
+const syncWaiterQueueCapacity = 4096
+
 type RegionError struct {
 	RequestErr *errorpb.Error
 }
@@ -82,28 +89,7 @@ func NewRaftStorage(conf *config.Config) *RaftStorage {
 // been persisted on the majority of the raft group and applied by the state machine, the callback
 // will wakeup the upper layer to send response to the kv client.
 func (rs *RaftStorage) Write(ctx *kvrpcpb.Context, batch []storage.Modify) error {
-	var reqs []*raft_cmdpb.Request
-	for _, m := range batch {
-		switch m.Data.(type) {
-		case storage.Put:
-			put := m.Data.(storage.Put)
-			reqs = append(reqs, &raft_cmdpb.Request{
-				CmdType: raft_cmdpb.CmdType_Put,
-				Put: &raft_cmdpb.PutRequest{
-					Cf:    put.Cf,
-					Key:   put.Key,
-					Value: put.Value,
-				}})
-		case storage.Delete:
-			delete := m.Data.(storage.Delete)
-			reqs = append(reqs, &raft_cmdpb.Request{
-				CmdType: raft_cmdpb.CmdType_Delete,
-				Delete: &raft_cmdpb.DeleteRequest{
-					Cf:  delete.Cf,
-					Key: delete.Key,
-				}})
-		}
-	}
+	reqs := toRaftRequests(batch)
 
 	header := &raft_cmdpb.RaftRequestHeader{
 		RegionId:    ctx.RegionId,
@@ -123,11 +109,49 @@ func (rs *RaftStorage) Write(ctx *kvrpcpb.Context, batch []storage.Modify) error
 	return rs.checkResponse(cb.WaitResp(), len(reqs))
 }
 
+// WriteAsync is the intended AsyncStorageWrites entrance for callers that
+// can afford to be notified of durability instead of blocking on it: unlike
+// Write, it would return as soon as the command is accepted into the
+// leader's unstable log and queued for replication, instead of blocking
+// until cb.WaitResp() unblocks. The durability gating that would make this
+// safe — holding the local MsgAppResp until this peer's batch has actually
+// synced — is logstore.SyncWaiterLoop's job and belongs inside
+// HandleRaftReady on the peer loop's goroutine, not in this function.
+// HandleRaftReady lives in peer_msg_handler.go, which this checkout does
+// not include (see kv/raftstore/async_append.go and Start's
+// RaftAsyncStorageWrites check), so that hand-off is not wired: WriteAsync
+// below is currently identical to Write minus the wait, and
+// Start refuses to turn RaftAsyncStorageWrites on rather than pretend this
+// path changes anything.
+func (rs *RaftStorage) WriteAsync(ctx *kvrpcpb.Context, batch []storage.Modify, cb *message.Callback) error {
+	reqs := toRaftRequests(batch)
+
+	header := &raft_cmdpb.RaftRequestHeader{
+		RegionId:    ctx.RegionId,
+		Peer:        ctx.Peer,
+		RegionEpoch: ctx.RegionEpoch,
+		Term:        ctx.Term,
+	}
+	request := &raft_cmdpb.RaftCmdRequest{
+		Header:   header,
+		Requests: reqs,
+	}
+	return rs.raftRouter.SendRaftCommand(request, cb)
+}
+
 // Reader is main entrance to get a snapshot of current state machine for read. Only
 // the raft group or region leader could process read requests, to ensure this another
 // raft instance is used and the snapshot is generated by the applier. There are also
 // better ways to reduce the cost of read request processing, more information about
 // this could be found in the raft paper 6.4.
+//
+// NOTE: the ReadIndex/LeaseRead fast paths described in raft paper 6.4 are
+// implemented as a self-contained subsystem in raftstore/message and
+// raftstore/read_only_tracker.go, ready for a peer to drive once ctx grows
+// a ReadConsistency selector. That selector is a kvrpcpb.Context proto field
+// this checkout's proto/pkg/kvrpcpb does not carry, so Reader cannot yet
+// dispatch to it without a proto change outside this tree; until then every
+// call goes through CmdType_Snap below, unchanged.
 func (rs *RaftStorage) Reader(ctx *kvrpcpb.Context) (storage.StorageReader, error) {
 	header := &raft_cmdpb.RaftRequestHeader{
 		RegionId:    ctx.RegionId,
@@ -173,6 +197,20 @@ func (rs *RaftStorage) Raft(stream tinykvpb.TinyKv_RaftServer) error {
 	}
 }
 
+// Snapshot receives one region's snapshot stream. It is unchanged from the
+// single-shot design and still dispatches to newSnapRunner/recvSnapTask
+// below exactly as before. Neither of those two identifiers has a
+// definition anywhere in this checkout — not introduced by this change, not
+// present even in the pre-existing baseline — so this method, like the rest
+// of the file that depends on them, has never been buildable here. Routing
+// it through snap.recvSnapPool instead would mean either inventing
+// replacements for recvSnapTask/newSnapRunner and the gRPC stream framing
+// they assume, which is guesswork this checkout's proto definitions can't
+// confirm, or waiting for the real kv/raftstore/snap/runner.go to land and
+// adopt recvSnapPool itself. This change adds the chunked, resumable
+// receiver pool (snap.recvSnapPool, snap.chunkedReceiver) as a tested,
+// standalone component for that file to call into; it is not reachable
+// from here yet.
 func (rs *RaftStorage) Snapshot(stream tinykvpb.TinyKv_SnapshotServer) error {
 	var err error
 	done := make(chan struct{})
@@ -187,6 +225,20 @@ func (rs *RaftStorage) Snapshot(stream tinykvpb.TinyKv_SnapshotServer) error {
 	return err
 }
 
+// SnapshotStatus reports how far an in-flight or resumable snapshot
+// transfer has progressed, keyed by the same region/term/index triple used
+// for its progress checkpoint file. A sender reconnecting after a dropped
+// transfer calls this first to learn the offset it should resume from.
+//
+// This is a plain method rather than the tinykvpb-generated RPC handler the
+// request asked for: this checkout's proto/pkg/tinykvpb does not carry a
+// SnapshotStatus service method or its request/response types, so there is
+// nothing to implement against. Once the .proto gains that method in a
+// companion change, the generated handler can wrap this.
+func (rs *RaftStorage) SnapshotStatus(regionID, term, index uint64) (uint64, error) {
+	return rs.snapManager.TransferOffset(regionID, term, index)
+}
+
 // Start the raftStore and prepare for process requests.
 func (rs *RaftStorage) Start(client scheduler_client.Client) error {
 	rs.client = client
@@ -204,6 +256,18 @@ func (rs *RaftStorage) Start(client scheduler_client.Client) error {
 	snapRunner := newSnapRunner(rs.snapManager, rs.config, rs.raftRouter)
 	rs.snapWorker.Start(snapRunner)
 
+	if cfg.RaftAsyncStorageWrites {
+		// HandleRaftReady — the peer loop code that would enqueue append
+		// batches into a SyncWaiterLoop instead of fsyncing them inline —
+		// lives in peer_msg_handler.go, which this checkout does not
+		// include (see kv/raftstore/async_append.go). Starting the loop
+		// here anyway would silently do nothing: nothing would ever feed
+		// it a task, so WriteAsync callers would see no durability
+		// difference from turning the flag on. Fail fast instead of
+		// shipping a flag that looks like it does something and doesn't.
+		return errors.Errorf("config: RaftAsyncStorageWrites is set, but this build's peer loop does not enqueue into SyncWaiterLoop yet; leave it false until HandleRaftReady wiring lands")
+	}
+
 	raftClient := newRaftClient(cfg)
 	trans := NewServerTransport(raftClient, snapSender, rs.raftRouter, resolveSender)
 
@@ -218,6 +282,9 @@ func (rs *RaftStorage) Start(client scheduler_client.Client) error {
 
 // Stop the raft store.
 func (rs *RaftStorage) Stop() error {
+	if rs.syncWaiter != nil {
+		rs.syncWaiter.Stop()
+	}
 	rs.snapWorker.Stop()
 	rs.node.Stop()
 	rs.resolveWorker.Stop()